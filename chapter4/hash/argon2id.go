@@ -0,0 +1,72 @@
+package hash
+
+import (
+	"crypto/subtle"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2Params holds the tunable cost parameters for an Argon2id
+// derivation.
+type Argon2Params struct {
+	Time        uint32
+	Memory      uint32 // in KiB
+	Parallelism uint8
+	KeyLen      uint32
+}
+
+// DefaultArgon2Params is used by DeriveKeyArgon2id: a single pass over
+// 64 MiB of memory with four lanes, producing a 32-byte key.
+var DefaultArgon2Params = Argon2Params{
+	Time:        1,
+	Memory:      64 * 1024,
+	Parallelism: 4,
+	KeyLen:      32,
+}
+
+// Argon2Key is a password hash produced with Argon2id. The
+// parameters must travel with the salt and key, since they are
+// required to reproduce the derivation.
+type Argon2Key struct {
+	PasswordKey
+	Params Argon2Params
+}
+
+// DeriveKeyArgon2id generates a salt and derives a key from password
+// using Argon2id with DefaultArgon2Params.
+func DeriveKeyArgon2id(password string) *Argon2Key {
+	salt := generateSalt(SaltLength)
+	return DeriveKeyArgon2idWithSalt(password, salt, DefaultArgon2Params)
+}
+
+// DeriveKeyArgon2idWithSalt hashes the password with the specified
+// salt and parameters using Argon2id.
+func DeriveKeyArgon2idWithSalt(password string, salt []byte, params Argon2Params) *Argon2Key {
+	key := argon2.IDKey([]byte(password), salt, params.Time, params.Memory,
+		params.Parallelism, params.KeyLen)
+	return &Argon2Key{
+		PasswordKey: PasswordKey{Salt: salt, Key: key},
+		Params:      params,
+	}
+}
+
+// MatchPasswordArgon2id compares the input password with the password
+// hash. It returns true if they match.
+func MatchPasswordArgon2id(password string, pk *Argon2Key) bool {
+	newKey := DeriveKeyArgon2idWithSalt(password, pk.Salt, pk.Params)
+
+	matched := 0
+	size := len(newKey.Key)
+	if size > len(pk.Key) {
+		size = len(pk.Key)
+	}
+
+	for i := 0; i < size; i++ {
+		matched += subtle.ConstantTimeByteEq(newKey.Key[i], pk.Key[i])
+	}
+
+	if len(newKey.Key) != len(pk.Key) {
+		return false
+	}
+	return matched == size
+}
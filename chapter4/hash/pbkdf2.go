@@ -36,9 +36,20 @@ func DeriveKey(password string) *PasswordKey {
 	return DeriveKeyWithSalt(password, salt)
 }
 
-// DeriveKeyWithSalt hashes the password with the specified salt.
+// DeriveKeyWithSalt hashes the password with the specified salt,
+// using the package-level IterationCount.
 func DeriveKeyWithSalt(password string, salt []byte) (ph *PasswordKey) {
-	key := pbkdf2.Key([]byte(password), salt, IterationCount,
+	return DeriveKeyWithIterations(password, salt, IterationCount)
+}
+
+// DeriveKeyWithIterations hashes the password with the specified salt
+// and iteration count. Unlike DeriveKeyWithSalt, it does not depend on
+// the package-level IterationCount, so callers that must persist and
+// later reproduce a specific iteration count (see hash.Marshal and
+// hash.Verify) can do so safely even if IterationCount changes later
+// or the call happens concurrently with another caller's use of it.
+func DeriveKeyWithIterations(password string, salt []byte, iterations int) *PasswordKey {
+	key := pbkdf2.Key([]byte(password), salt, iterations,
 		KeySize, DefaultAlgo.New)
 	return &PasswordKey{salt, key}
 }
@@ -0,0 +1,208 @@
+package hash
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Algorithm identifiers used in the PHC/modular-crypt encoding.
+const (
+	algoPBKDF2SHA256 = "pbkdf2-sha256"
+	algoArgon2id     = "argon2id"
+	argon2Version    = 19
+)
+
+var phcEncoding = base64.RawStdEncoding
+
+// PBKDF2Key is a password hash produced with PBKDF2. The iteration
+// count must travel with the salt and key: IterationCount is a
+// package-level tuning knob that may be raised over time, and a
+// stored record must keep re-deriving with whatever count produced
+// it, not whatever the package global happens to be set to later.
+type PBKDF2Key struct {
+	PasswordKey
+	Iterations int
+}
+
+// DeriveKeyPBKDF2 generates a salt and derives a key using PBKDF2 with
+// the package's current IterationCount.
+func DeriveKeyPBKDF2(password string) *PBKDF2Key {
+	salt := generateSalt(SaltLength)
+	return DeriveKeyPBKDF2WithSalt(password, salt, IterationCount)
+}
+
+// DeriveKeyPBKDF2WithSalt hashes the password with the specified salt
+// and iteration count using PBKDF2.
+func DeriveKeyPBKDF2WithSalt(password string, salt []byte, iterations int) *PBKDF2Key {
+	pk := DeriveKeyWithIterations(password, salt, iterations)
+	return &PBKDF2Key{PasswordKey: *pk, Iterations: iterations}
+}
+
+// MatchPasswordPBKDF2 compares the input password with the password
+// hash, re-deriving with the iteration count recorded on pk rather
+// than the package-level IterationCount.
+func MatchPasswordPBKDF2(password string, pk *PBKDF2Key) bool {
+	newKey := DeriveKeyWithIterations(password, pk.Salt, pk.Iterations)
+
+	matched := 0
+	size := len(newKey.Key)
+	if size > len(pk.Key) {
+		size = len(pk.Key)
+	}
+
+	for i := 0; i < size; i++ {
+		matched += subtle.ConstantTimeByteEq(newKey.Key[i], pk.Key[i])
+	}
+
+	if len(newKey.Key) != len(pk.Key) {
+		return false
+	}
+	return matched == size
+}
+
+// Marshal encodes pk as a PBKDF2 PHC string:
+//
+//	$pbkdf2-sha256$i=16384$<b64 salt>$<b64 hash>
+func Marshal(pk *PBKDF2Key) string {
+	return fmt.Sprintf("$%s$i=%d$%s$%s", algoPBKDF2SHA256, pk.Iterations,
+		phcEncoding.EncodeToString(pk.Salt), phcEncoding.EncodeToString(pk.Key))
+}
+
+// MarshalArgon2id encodes pk as an Argon2id PHC string:
+//
+//	$argon2id$v=19$m=65536,t=1,p=4$<b64 salt>$<b64 hash>
+func MarshalArgon2id(pk *Argon2Key) string {
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", algoArgon2id, argon2Version,
+		pk.Params.Memory, pk.Params.Time, pk.Params.Parallelism,
+		phcEncoding.EncodeToString(pk.Salt), phcEncoding.EncodeToString(pk.Key))
+}
+
+// Parse decodes a PHC string produced by Marshal or MarshalArgon2id,
+// dispatching on the algorithm identifier. It returns either a
+// *PBKDF2Key (pbkdf2-sha256) or an *Argon2Key (argon2id).
+func Parse(s string) (interface{}, error) {
+	fields := strings.Split(s, "$")
+	if len(fields) < 2 || fields[0] != "" {
+		return nil, fmt.Errorf("hash: malformed PHC string")
+	}
+
+	switch fields[1] {
+	case algoPBKDF2SHA256:
+		return parsePBKDF2(fields[1:])
+	case algoArgon2id:
+		return parseArgon2id(fields[1:])
+	default:
+		return nil, fmt.Errorf("hash: unknown algorithm %q", fields[1])
+	}
+}
+
+// parsePBKDF2 parses the fields following the leading "$" of a
+// pbkdf2-sha256 PHC string: [algo, "i=...", salt, hash].
+func parsePBKDF2(fields []string) (*PBKDF2Key, error) {
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("hash: malformed pbkdf2-sha256 PHC string")
+	}
+
+	if !strings.HasPrefix(fields[1], "i=") {
+		return nil, fmt.Errorf("hash: malformed pbkdf2-sha256 parameters")
+	}
+	iterations, err := strconv.Atoi(fields[1][2:])
+	if err != nil {
+		return nil, fmt.Errorf("hash: invalid iteration count: %v", err)
+	}
+
+	salt, err := phcEncoding.DecodeString(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("hash: invalid salt: %v", err)
+	}
+
+	key, err := phcEncoding.DecodeString(fields[3])
+	if err != nil {
+		return nil, fmt.Errorf("hash: invalid key: %v", err)
+	}
+
+	return &PBKDF2Key{PasswordKey: PasswordKey{Salt: salt, Key: key}, Iterations: iterations}, nil
+}
+
+// parseArgon2id parses the fields following the leading "$" of an
+// argon2id PHC string: [algo, "v=...", "m=...,t=...,p=...", salt, hash].
+func parseArgon2id(fields []string) (*Argon2Key, error) {
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("hash: malformed argon2id PHC string")
+	}
+
+	if !strings.HasPrefix(fields[1], "v=") {
+		return nil, fmt.Errorf("hash: malformed argon2id version field")
+	}
+	version, err := strconv.Atoi(fields[1][2:])
+	if err != nil {
+		return nil, fmt.Errorf("hash: invalid argon2id version: %v", err)
+	}
+	if version != argon2Version {
+		return nil, fmt.Errorf("hash: unsupported argon2id version %d", version)
+	}
+
+	var params Argon2Params
+	for _, kv := range strings.Split(fields[2], ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("hash: malformed argon2id parameters")
+		}
+
+		n, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("hash: invalid argon2id parameter %q: %v", kv, err)
+		}
+
+		switch parts[0] {
+		case "m":
+			params.Memory = uint32(n)
+		case "t":
+			params.Time = uint32(n)
+		case "p":
+			params.Parallelism = uint8(n)
+		default:
+			return nil, fmt.Errorf("hash: unknown argon2id parameter %q", parts[0])
+		}
+	}
+
+	if params.Memory == 0 || params.Time == 0 || params.Parallelism == 0 {
+		return nil, fmt.Errorf("hash: argon2id parameters must all be present and non-zero")
+	}
+
+	salt, err := phcEncoding.DecodeString(fields[3])
+	if err != nil {
+		return nil, fmt.Errorf("hash: invalid salt: %v", err)
+	}
+
+	key, err := phcEncoding.DecodeString(fields[4])
+	if err != nil {
+		return nil, fmt.Errorf("hash: invalid key: %v", err)
+	}
+	params.KeyLen = uint32(len(key))
+
+	return &Argon2Key{PasswordKey: PasswordKey{Salt: salt, Key: key}, Params: params}, nil
+}
+
+// Verify checks password against a PHC-encoded hash produced by
+// Marshal or MarshalArgon2id, dispatching on the embedded algorithm
+// identifier. It lets callers store a single string per user and
+// upgrade algorithms or parameters over time without a schema change.
+func Verify(password, phc string) (bool, error) {
+	parsed, err := Parse(phc)
+	if err != nil {
+		return false, err
+	}
+
+	switch pk := parsed.(type) {
+	case *PBKDF2Key:
+		return MatchPasswordPBKDF2(password, pk), nil
+	case *Argon2Key:
+		return MatchPasswordArgon2id(password, pk), nil
+	default:
+		return false, fmt.Errorf("hash: unsupported parsed type %T", parsed)
+	}
+}
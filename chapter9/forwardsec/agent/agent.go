@@ -0,0 +1,109 @@
+// Package agent implements an ssh-agent-style local signing agent
+// for forwardsec identity and session keys. Long-term identity keys
+// and ephemeral session DH keys are generated and held inside the
+// agent process; callers reach them over a length-prefixed
+// request/reply protocol on a UNIX socket, and private key material
+// never crosses the socket.
+package agent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Opcodes for the agent's request/reply protocol, in the spirit of
+// the ssh-agent wire format.
+const (
+	opRequestIdentity byte = 1
+	opSign            byte = 2
+	opNewSessionKey   byte = 3
+	opSessionDecrypt  byte = 4
+
+	opOK    byte = 0x80
+	opError byte = 0xff
+)
+
+// maxFrame bounds how large a single request/reply frame may be, so
+// that a misbehaving peer cannot force an unbounded allocation.
+const maxFrame = 1 << 20
+
+type signRequest struct {
+	Message []byte
+}
+
+type signResponse struct {
+	Signature []byte
+}
+
+type requestIdentityResponse struct {
+	Public []byte
+}
+
+type newSessionKeyResponse struct {
+	Handle uint32
+	Public []byte
+}
+
+type sessionDecryptRequest struct {
+	Handle uint32
+	In     []byte
+}
+
+type sessionDecryptResponse struct {
+	Plaintext []byte
+}
+
+type errorResponse struct {
+	Message string
+}
+
+// writeFrame writes a length-prefixed frame: a 4-byte big-endian
+// length covering the opcode and payload, the opcode byte, and the
+// gob-encoded payload (v may be nil for payload-less frames).
+func writeFrame(conn net.Conn, op byte, v interface{}) error {
+	var payload bytes.Buffer
+	if v != nil {
+		if err := gob.NewEncoder(&payload).Encode(v); err != nil {
+			return err
+		}
+	}
+
+	frame := make([]byte, 5+payload.Len())
+	binary.BigEndian.PutUint32(frame[:4], uint32(1+payload.Len()))
+	frame[4] = op
+	copy(frame[5:], payload.Bytes())
+
+	_, err := conn.Write(frame)
+	return err
+}
+
+// readFrame reads a frame written by writeFrame and returns its
+// opcode and raw (still gob-encoded) payload.
+func readFrame(conn net.Conn) (byte, []byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return 0, nil, err
+	}
+
+	n := binary.BigEndian.Uint32(length[:])
+	if n == 0 {
+		return 0, nil, fmt.Errorf("agent: empty frame")
+	}
+	if n > maxFrame {
+		return 0, nil, fmt.Errorf("agent: frame too large: %d bytes", n)
+	}
+
+	body := make([]byte, n)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return 0, nil, err
+	}
+	return body[0], body[1:], nil
+}
+
+func decodePayload(payload []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(payload)).Decode(v)
+}
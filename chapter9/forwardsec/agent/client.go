@@ -0,0 +1,155 @@
+package agent
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// Client talks to an agent over a UNIX socket. It implements
+// forwardsec.Signer, so it can back a forwardsec.IdentityKey (via
+// forwardsec.NewRemoteIdentityKey) transparently: NewSessionKey and
+// NewX25519SessionKey then sign through the agent without the
+// identity key's private material ever leaving it.
+type Client struct {
+	mu   sync.Mutex
+	conn net.Conn
+	pub  crypto.PublicKey
+}
+
+// Dial connects to the agent listening on path and fetches its public
+// identity.
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{conn: conn}
+	pubBytes, err := c.requestIdentity()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(pubBytes)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.pub = pub
+	return c, nil
+}
+
+// Close closes the connection to the agent.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// RequestIdentity returns the agent's tagged public identity key, as
+// produced by forwardsec.IdentityKey.Public.
+func (c *Client) RequestIdentity() ([]byte, error) {
+	return c.requestIdentity()
+}
+
+func (c *Client) requestIdentity() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := writeFrame(c.conn, opRequestIdentity, nil); err != nil {
+		return nil, err
+	}
+
+	var resp requestIdentityResponse
+	if err := c.roundTrip(&resp); err != nil {
+		return nil, err
+	}
+	return resp.Public, nil
+}
+
+// Public returns the agent's identity public key, satisfying
+// forwardsec.Signer.
+func (c *Client) Public() crypto.PublicKey {
+	return c.pub
+}
+
+// Sign asks the agent to sign message with its identity key,
+// satisfying forwardsec.Signer. The rand argument is accepted for
+// interface compatibility but unused: randomness for signing happens
+// inside the agent.
+func (c *Client) Sign(rand io.Reader, message []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := writeFrame(c.conn, opSign, &signRequest{Message: message}); err != nil {
+		return nil, err
+	}
+
+	var resp signResponse
+	if err := c.roundTrip(&resp); err != nil {
+		return nil, err
+	}
+	return resp.Signature, nil
+}
+
+// NewSessionKey asks the agent to generate, sign, and hold a fresh
+// session DH keypair, returning its handle and signed public blob.
+// The handle is later passed to SessionDecrypt; the private key never
+// leaves the agent.
+func (c *Client) NewSessionKey() (handle uint32, public []byte, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err = writeFrame(c.conn, opNewSessionKey, nil); err != nil {
+		return 0, nil, err
+	}
+
+	var resp newSessionKeyResponse
+	if err = c.roundTrip(&resp); err != nil {
+		return 0, nil, err
+	}
+	return resp.Handle, resp.Public, nil
+}
+
+// SessionDecrypt asks the agent to decrypt in (as produced by the
+// peer's SessionKey.Encrypt) using the session identified by handle.
+func (c *Client) SessionDecrypt(handle uint32, in []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	req := sessionDecryptRequest{Handle: handle, In: in}
+	if err := writeFrame(c.conn, opSessionDecrypt, &req); err != nil {
+		return nil, err
+	}
+
+	var resp sessionDecryptResponse
+	if err := c.roundTrip(&resp); err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
+
+// roundTrip reads a single reply frame and decodes it into v, or
+// returns the agent's reported error. Callers must hold c.mu.
+func (c *Client) roundTrip(v interface{}) error {
+	op, payload, err := readFrame(c.conn)
+	if err != nil {
+		return err
+	}
+
+	switch op {
+	case opOK:
+		return decodePayload(payload, v)
+	case opError:
+		var errResp errorResponse
+		if err := decodePayload(payload, &errResp); err != nil {
+			return err
+		}
+		return fmt.Errorf("agent: %s", errResp.Message)
+	default:
+		return fmt.Errorf("agent: unexpected opcode %d in reply", op)
+	}
+}
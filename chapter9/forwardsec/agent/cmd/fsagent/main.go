@@ -0,0 +1,61 @@
+// Command fsagent is a reference implementation of a forwardsec
+// signing agent: it generates an identity key, listens on a UNIX
+// socket, and answers agent requests until interrupted. The identity
+// key's private material never leaves this process.
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/kisom/gocrypto/chapter9/forwardsec"
+	"github.com/kisom/gocrypto/chapter9/forwardsec/agent"
+)
+
+func main() {
+	sockPath := flag.String("sock", "/tmp/fsagent.sock", "path to the agent's UNIX socket")
+	useEd25519 := flag.Bool("ed25519", false, "generate an Ed25519 identity instead of RSA-3072")
+	flag.Parse()
+
+	var id *forwardsec.IdentityKey
+	if *useEd25519 {
+		id = forwardsec.NewEd25519IdentityKey()
+	} else {
+		id = forwardsec.NewIdentityKey()
+	}
+	if id == nil {
+		fmt.Fprintln(os.Stderr, "fsagent: failed to generate identity key")
+		os.Exit(1)
+	}
+
+	os.Remove(*sockPath)
+
+	oldMask := syscall.Umask(0077)
+	l, err := net.Listen("unix", *sockPath)
+	syscall.Umask(oldMask)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fsagent: listen:", err)
+		os.Exit(1)
+	}
+	defer os.Remove(*sockPath)
+
+	fmt.Printf("fsagent: listening on %s\n", *sockPath)
+	fmt.Printf("fsagent: public identity: %s\n", base64.StdEncoding.EncodeToString(id.Public()))
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		l.Close()
+	}()
+
+	if err := agent.Serve(l, id); err != nil {
+		fmt.Fprintln(os.Stderr, "fsagent:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,151 @@
+package agent
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/kisom/gocrypto/chapter9/forwardsec"
+)
+
+// Serve answers agent requests on l on behalf of id. If l is a UNIX
+// listener, the socket is restricted to mode 0600 and owned by the
+// calling process's uid before Serve starts accepting connections.
+// Serve blocks until l.Accept fails (for example, because l was
+// closed), at which point it returns that error.
+func Serve(l net.Listener, id *forwardsec.IdentityKey) error {
+	if err := secureSocket(l); err != nil {
+		return err
+	}
+
+	srv := &server{id: id, sessions: make(map[uint32]*forwardsec.SessionKey)}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go srv.serveConn(conn)
+	}
+}
+
+// secureSocket enforces 0600 permissions and uid ownership on a UNIX
+// domain socket's path so that only the invoking user can reach the
+// agent.
+func secureSocket(l net.Listener) error {
+	ul, ok := l.(*net.UnixListener)
+	if !ok {
+		return nil
+	}
+
+	addr := ul.Addr()
+	if addr == nil || addr.Network() != "unix" {
+		return nil
+	}
+	path := addr.String()
+
+	if err := os.Chmod(path, 0600); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if stat.Uid != uint32(os.Getuid()) {
+		return fmt.Errorf("agent: socket %s is not owned by the current user", path)
+	}
+	if info.Mode().Perm() != 0600 {
+		return fmt.Errorf("agent: socket %s has loose permissions %o", path, info.Mode().Perm())
+	}
+	return nil
+}
+
+type server struct {
+	id *forwardsec.IdentityKey
+
+	mu       sync.Mutex
+	sessions map[uint32]*forwardsec.SessionKey
+	nextID   uint32
+}
+
+func (s *server) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		op, payload, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+		if err := s.handle(conn, op, payload); err != nil {
+			// A request-level error (bad opcode, unknown session
+			// handle, failed decrypt, ...) doesn't mean the
+			// connection is broken; report it and keep serving the
+			// client's later requests. Only a failure to write the
+			// error frame itself indicates the transport is gone.
+			if werr := writeFrame(conn, opError, &errorResponse{Message: err.Error()}); werr != nil {
+				return
+			}
+			continue
+		}
+	}
+}
+
+func (s *server) handle(conn net.Conn, op byte, payload []byte) error {
+	switch op {
+	case opRequestIdentity:
+		return writeFrame(conn, opOK, &requestIdentityResponse{Public: s.id.Public()})
+
+	case opSign:
+		var req signRequest
+		if err := decodePayload(payload, &req); err != nil {
+			return err
+		}
+		sig, err := s.id.Sign(req.Message)
+		if err != nil {
+			return err
+		}
+		return writeFrame(conn, opOK, &signResponse{Signature: sig})
+
+	case opNewSessionKey:
+		skey := s.id.NewSessionKey()
+		if skey == nil {
+			return fmt.Errorf("agent: failed to generate session key")
+		}
+
+		s.mu.Lock()
+		s.nextID++
+		handle := s.nextID
+		s.sessions[handle] = skey
+		s.mu.Unlock()
+
+		return writeFrame(conn, opOK, &newSessionKeyResponse{Handle: handle, Public: skey.Public()})
+
+	case opSessionDecrypt:
+		var req sessionDecryptRequest
+		if err := decodePayload(payload, &req); err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		skey, ok := s.sessions[req.Handle]
+		s.mu.Unlock()
+		if !ok {
+			return fmt.Errorf("agent: unknown session handle %d", req.Handle)
+		}
+
+		plaintext, err := skey.Decrypt(req.In)
+		if err != nil {
+			return err
+		}
+		return writeFrame(conn, opOK, &sessionDecryptResponse{Plaintext: plaintext})
+
+	default:
+		return fmt.Errorf("agent: unknown opcode %d", op)
+	}
+}
@@ -0,0 +1,150 @@
+package forwardsec
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"io"
+
+	"github.com/kisom/gocrypto/chapter8/pks"
+)
+
+// Signer is the common signing interface for identity key material.
+// It is satisfied by the in-process RSA and Ed25519 wrappers below,
+// and may also be satisfied by a remote signer (see forwardsec/agent)
+// so that an IdentityKey's private material never has to live in the
+// calling process.
+type Signer interface {
+	Sign(rand io.Reader, message []byte) ([]byte, error)
+	Public() crypto.PublicKey
+}
+
+// Verifier checks a signature produced by the peer's Signer.
+type Verifier interface {
+	Verify(message, sig []byte) error
+}
+
+// IdentityKey represents a long-term identity key, either RSA-3072 or
+// Ed25519.
+type IdentityKey struct {
+	key Signer
+}
+
+// NewIdentityKey builds a new RSA-3072 identity key.
+func NewIdentityKey() *IdentityKey {
+	key, err := pks.GenerateKey()
+	if err != nil {
+		return nil
+	}
+	return &IdentityKey{key: rsaSigner{key}}
+}
+
+// NewEd25519IdentityKey builds a new Ed25519 identity key.
+func NewEd25519IdentityKey() *IdentityKey {
+	_, key, err := ed25519.GenerateKey(PRNG)
+	if err != nil {
+		return nil
+	}
+	return &IdentityKey{key: ed25519Signer{key}}
+}
+
+// NewRemoteIdentityKey wraps an out-of-process Signer (for example,
+// an agent.Client) as an IdentityKey, so that NewSessionKey and
+// NewX25519SessionKey can sign without the private key ever entering
+// this process.
+func NewRemoteIdentityKey(s Signer) *IdentityKey {
+	return &IdentityKey{key: s}
+}
+
+// Sign signs message with the identity key, whether it is held
+// in-process or behind a remote agent.
+func (id *IdentityKey) Sign(message []byte) ([]byte, error) {
+	return id.key.Sign(PRNG, message)
+}
+
+// Public returns the tagged (algorithm OID + key bytes) public
+// identity key.
+func (id *IdentityKey) Public() []byte {
+	cert, err := x509.MarshalPKIXPublicKey(id.key.Public())
+	if err != nil {
+		return nil
+	}
+	return cert
+}
+
+// ImportPeerIdentity takes an exported public identity key and
+// returns a Verifier for whichever algorithm (RSA or Ed25519) it was
+// tagged with.
+func ImportPeerIdentity(in []byte) (Verifier, error) {
+	if in == nil {
+		return nil, fmt.Errorf("forwardsec: no identity key given")
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(in)
+	if err != nil {
+		return nil, err
+	}
+	return newVerifier(pub)
+}
+
+func newVerifier(pub crypto.PublicKey) (Verifier, error) {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		return rsaVerifier{key}, nil
+	case ed25519.PublicKey:
+		return ed25519Verifier{key}, nil
+	default:
+		return nil, fmt.Errorf("forwardsec: unsupported identity key type %T", pub)
+	}
+}
+
+// rsaSigner and ed25519Signer adapt the standard library key types to
+// the Signer interface.
+
+type rsaSigner struct {
+	key *rsa.PrivateKey
+}
+
+func (s rsaSigner) Sign(rand io.Reader, message []byte) ([]byte, error) {
+	return pks.Sign(s.key, message)
+}
+
+func (s rsaSigner) Public() crypto.PublicKey {
+	return &s.key.PublicKey
+}
+
+type ed25519Signer struct {
+	key ed25519.PrivateKey
+}
+
+func (s ed25519Signer) Sign(rand io.Reader, message []byte) ([]byte, error) {
+	return ed25519.Sign(s.key, message), nil
+}
+
+func (s ed25519Signer) Public() crypto.PublicKey {
+	return s.key.Public()
+}
+
+// rsaVerifier and ed25519Verifier adapt the standard library key
+// types to the Verifier interface.
+
+type rsaVerifier struct {
+	key *rsa.PublicKey
+}
+
+func (v rsaVerifier) Verify(message, sig []byte) error {
+	return pks.Verify(v.key, message, sig)
+}
+
+type ed25519Verifier struct {
+	key ed25519.PublicKey
+}
+
+func (v ed25519Verifier) Verify(message, sig []byte) error {
+	if !ed25519.Verify(v.key, message, sig) {
+		return fmt.Errorf("forwardsec: invalid ed25519 signature")
+	}
+	return nil
+}
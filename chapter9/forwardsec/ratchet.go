@@ -0,0 +1,406 @@
+package forwardsec
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/gob"
+	"fmt"
+	"io"
+
+	"github.com/gokyle/dhkam"
+	"github.com/kisom/gocrypto/chapter9/authsym"
+	"golang.org/x/crypto/hkdf"
+)
+
+// maxSkippedKeys bounds how many out-of-order message keys a session
+// will buffer in total, across all receiving chains, so a peer cannot
+// force unbounded memory growth by claiming a huge message counter.
+const maxSkippedKeys = 1000
+
+// ratchetState is a Double Ratchet (Signal protocol) state machine
+// layered on top of a SessionKey's existing DH keypair. Reusing the
+// session's semi-static DH key only gets forward secrecy per
+// session; the ratchet additionally rotates the DH key on every
+// direction change, so compromising DHs/DHr at any one point in time
+// does not expose past messages (forward secrecy) and a fresh DH
+// keypair heals the session going forward even after a compromise
+// (post-compromise security).
+type ratchetState struct {
+	RK  []byte
+	CKs []byte
+	CKr []byte
+	DHs *dhkam.PrivateKey
+	DHr *dhkam.PublicKey
+	Ns  int
+	Nr  int
+	PN  int
+
+	// Skipped holds message keys for messages that arrived out of
+	// order, keyed by the sender's ratchet public key and message
+	// number.
+	Skipped map[skippedKeyID][]byte
+}
+
+type skippedKeyID struct {
+	DH string
+	N  int
+}
+
+type ratchetHeader struct {
+	DH []byte
+	PN int
+	N  int
+}
+
+type ratchetMessage struct {
+	Header ratchetHeader
+	CT     []byte
+}
+
+// InitRatchetSender starts ratchet state for the party that sends the
+// first ratcheted message. It must be called after PeerSessionKey.
+func (skey *SessionKey) InitRatchetSender() error {
+	if skey.peer == nil {
+		return fmt.Errorf("forwardsec: PeerSessionKey must be called before InitRatchetSender")
+	}
+
+	sk, err := skey.key.SharedKey(PRNG, skey.peer, 32)
+	if err != nil {
+		return err
+	}
+
+	dhs, err := dhkam.GenerateKey(PRNG)
+	if err != nil {
+		return err
+	}
+
+	dh, err := dhs.SharedKey(PRNG, skey.peer, 32)
+	if err != nil {
+		return err
+	}
+
+	rk, cks := rootKDF(sk, dh)
+	skey.ratchet = &ratchetState{
+		RK:      rk,
+		CKs:     cks,
+		DHs:     dhs,
+		DHr:     skey.peer,
+		Skipped: make(map[skippedKeyID][]byte),
+	}
+	return nil
+}
+
+// InitRatchetReceiver starts ratchet state for the party that waits
+// for the first ratcheted message before it can send. It must be
+// called after PeerSessionKey.
+func (skey *SessionKey) InitRatchetReceiver() error {
+	if skey.peer == nil {
+		return fmt.Errorf("forwardsec: PeerSessionKey must be called before InitRatchetReceiver")
+	}
+
+	sk, err := skey.key.SharedKey(PRNG, skey.peer, 32)
+	if err != nil {
+		return err
+	}
+
+	skey.ratchet = &ratchetState{
+		RK:      sk,
+		DHs:     skey.key,
+		Skipped: make(map[skippedKeyID][]byte),
+	}
+	return nil
+}
+
+// rootKDF advances the root chain: given the current root key and a
+// fresh DH output, it derives the next root key and a new chain key.
+func rootKDF(rk, dh []byte) (newRK, chainKey []byte) {
+	out := make([]byte, 64)
+	kdf := hkdf.New(sha256.New, dh, rk, []byte("forwardsec ratchet root"))
+	io.ReadFull(kdf, out)
+	return out[:32], out[32:]
+}
+
+// chainKDF advances a sending or receiving chain: it derives the next
+// chain key and the message key used to encrypt or decrypt the
+// current message.
+func chainKDF(ck []byte) (newCK, messageKey []byte) {
+	newCK = hmacSHA256(ck, []byte{0x02})
+
+	messageKey = make([]byte, sharedKeyLen)
+	kdf := hkdf.New(sha256.New, ck, nil, []byte("forwardsec ratchet message"))
+	io.ReadFull(kdf, messageKey)
+	return
+}
+
+func hmacSHA256(key, message []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(message)
+	return mac.Sum(nil)
+}
+
+func skippedID(dh []byte, n int) skippedKeyID {
+	return skippedKeyID{DH: string(dh), N: n}
+}
+
+// clone returns a copy of rs that DecryptRatchet can try a candidate
+// header's ratchet mutations on, only committing them to the session
+// once the ciphertext has actually authenticated. Skipped starts out
+// shared with rs rather than copied: the common case (an in-order
+// message on the current chain) never touches it, and copying a map
+// that can hold up to maxSkippedKeys entries on every decrypt would
+// be wasteful. skipReceiving takes its own copy-on-write before it
+// adds anything, so rs.Skipped is never mutated through trial.
+func (rs *ratchetState) clone() *ratchetState {
+	return &ratchetState{
+		RK:      rs.RK,
+		CKs:     rs.CKs,
+		CKr:     rs.CKr,
+		DHs:     rs.DHs,
+		DHr:     rs.DHr,
+		Ns:      rs.Ns,
+		Nr:      rs.Nr,
+		PN:      rs.PN,
+		Skipped: rs.Skipped,
+	}
+}
+
+// EncryptRatchet encrypts message for the session's peer, advancing
+// the sending chain by one step. InitRatchetSender or
+// InitRatchetReceiver (plus at least one received message) must have
+// run first.
+func (skey *SessionKey) EncryptRatchet(message []byte) ([]byte, error) {
+	rs := skey.ratchet
+	if rs == nil {
+		return nil, fmt.Errorf("forwardsec: ratchet not initialized")
+	}
+	if rs.CKs == nil {
+		return nil, fmt.Errorf("forwardsec: no sending chain yet; must receive a message first")
+	}
+
+	var mk []byte
+	rs.CKs, mk = chainKDF(rs.CKs)
+
+	header := ratchetHeader{DH: rs.DHs.Export(), PN: rs.PN, N: rs.Ns}
+	rs.Ns++
+
+	symkey, mackey := mk[:authsym.SymKeyLen], mk[authsym.SymKeyLen:]
+	ct, err := authsym.Encrypt(symkey, mackey, message)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(ratchetMessage{Header: header, CT: ct})
+}
+
+// DecryptRatchet decrypts a message produced by EncryptRatchet,
+// performing a DH ratchet step when the sender's header carries a new
+// ratchet public key, and buffering skipped message keys so that
+// messages may arrive out of order.
+//
+// A ratchet header is unauthenticated on its own: only authsym.Decrypt
+// checking the message's MAC tells us the header was genuine. So every
+// ratchet-state mutation implied by a header (skipping keys, rotating
+// DHr/DHs/RK/CKr/CKs) is performed on a clone of the real state, and
+// that clone only replaces skey.ratchet once decryption has actually
+// succeeded. Otherwise a forged header with a made-up header.DH would
+// permanently desync the session before its bogus ciphertext was ever
+// checked.
+func (skey *SessionKey) DecryptRatchet(in []byte) ([]byte, error) {
+	rs := skey.ratchet
+	if rs == nil {
+		return nil, fmt.Errorf("forwardsec: ratchet not initialized")
+	}
+
+	var msg ratchetMessage
+	if _, err := asn1.Unmarshal(in, &msg); err != nil {
+		return nil, err
+	}
+	header := msg.Header
+
+	if mk, ok := rs.Skipped[skippedID(header.DH, header.N)]; ok {
+		symkey, mackey := mk[:authsym.SymKeyLen], mk[authsym.SymKeyLen:]
+		plaintext, err := authsym.Decrypt(symkey, mackey, msg.CT)
+		if err != nil {
+			return nil, err
+		}
+		delete(rs.Skipped, skippedID(header.DH, header.N))
+		return plaintext, nil
+	}
+
+	trial := rs.clone()
+
+	if trial.DHr == nil || !bytes.Equal(trial.DHr.Export(), header.DH) {
+		if err := trial.skipReceiving(header.PN); err != nil {
+			return nil, err
+		}
+		if err := trial.dhRatchet(header.DH); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := trial.skipReceiving(header.N); err != nil {
+		return nil, err
+	}
+
+	var mk []byte
+	trial.CKr, mk = chainKDF(trial.CKr)
+	trial.Nr++
+
+	symkey, mackey := mk[:authsym.SymKeyLen], mk[authsym.SymKeyLen:]
+	plaintext, err := authsym.Decrypt(symkey, mackey, msg.CT)
+	if err != nil {
+		return nil, err
+	}
+
+	skey.ratchet = trial
+	return plaintext, nil
+}
+
+// skipReceiving advances the receiving chain up to, but not
+// including, message number until, buffering each message key it
+// passes over. It is a no-op if the receiving chain has not started
+// yet (CKr is nil, i.e. no message has been received on it).
+func (rs *ratchetState) skipReceiving(until int) error {
+	if rs.CKr == nil || rs.Nr >= until {
+		return nil
+	}
+
+	if len(rs.Skipped)+(until-rs.Nr) > maxSkippedKeys {
+		return fmt.Errorf("forwardsec: skipped ratchet message buffer full")
+	}
+
+	skipped := make(map[skippedKeyID][]byte, len(rs.Skipped)+until-rs.Nr)
+	for k, v := range rs.Skipped {
+		skipped[k] = v
+	}
+	rs.Skipped = skipped
+
+	dh := rs.DHr.Export()
+	for rs.Nr < until {
+		var mk []byte
+		rs.CKr, mk = chainKDF(rs.CKr)
+		rs.Skipped[skippedID(dh, rs.Nr)] = mk
+		rs.Nr++
+	}
+	return nil
+}
+
+// dhRatchet performs the DH ratchet step described in DecryptRatchet's
+// doc comment: it folds in the peer's new
+// ratchet public key to start a fresh receiving chain, then generates
+// our own new ratchet keypair to start a fresh sending chain.
+func (rs *ratchetState) dhRatchet(peerPublic []byte) error {
+	peerPub, err := dhkam.ImportPublic(peerPublic)
+	if err != nil {
+		return err
+	}
+
+	rs.PN = rs.Ns
+	rs.Ns = 0
+	rs.Nr = 0
+	rs.DHr = peerPub
+
+	dh, err := rs.DHs.SharedKey(PRNG, rs.DHr, 32)
+	if err != nil {
+		return err
+	}
+	rs.RK, rs.CKr = rootKDF(rs.RK, dh)
+
+	newDHs, err := dhkam.GenerateKey(PRNG)
+	if err != nil {
+		return err
+	}
+	rs.DHs = newDHs
+
+	dh, err = rs.DHs.SharedKey(PRNG, rs.DHr, 32)
+	if err != nil {
+		return err
+	}
+	rs.RK, rs.CKs = rootKDF(rs.RK, dh)
+	return nil
+}
+
+// ratchetDTO is the on-disk representation of a SessionKey's ratchet
+// state, used by Marshal/Unmarshal so a session survives restarts.
+type ratchetDTO struct {
+	RK      []byte
+	CKs     []byte
+	CKr     []byte
+	DHs     *dhkam.PrivateKey
+	DHr     *dhkam.PublicKey
+	Ns      int
+	Nr      int
+	PN      int
+	Skipped map[skippedKeyID][]byte
+}
+
+// Marshal serializes the session's key material and ratchet state
+// (if InitRatchetSender/InitRatchetReceiver has been called) so it
+// can be persisted across restarts.
+func (skey *SessionKey) Marshal() ([]byte, error) {
+	dto := struct {
+		Key       *dhkam.PrivateKey
+		SignedKey []byte
+		Peer      *dhkam.PublicKey
+		Ratchet   *ratchetDTO
+	}{
+		Key:       skey.key,
+		SignedKey: skey.signedKey,
+		Peer:      skey.peer,
+	}
+
+	if skey.ratchet != nil {
+		dto.Ratchet = &ratchetDTO{
+			RK:      skey.ratchet.RK,
+			CKs:     skey.ratchet.CKs,
+			CKr:     skey.ratchet.CKr,
+			DHs:     skey.ratchet.DHs,
+			DHr:     skey.ratchet.DHr,
+			Ns:      skey.ratchet.Ns,
+			Nr:      skey.ratchet.Nr,
+			PN:      skey.ratchet.PN,
+			Skipped: skey.ratchet.Skipped,
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&dto); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal restores a SessionKey previously serialized with Marshal.
+func (skey *SessionKey) Unmarshal(data []byte) error {
+	var dto struct {
+		Key       *dhkam.PrivateKey
+		SignedKey []byte
+		Peer      *dhkam.PublicKey
+		Ratchet   *ratchetDTO
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&dto); err != nil {
+		return err
+	}
+
+	skey.key = dto.Key
+	skey.signedKey = dto.SignedKey
+	skey.peer = dto.Peer
+
+	if dto.Ratchet != nil {
+		skey.ratchet = &ratchetState{
+			RK:      dto.Ratchet.RK,
+			CKs:     dto.Ratchet.CKs,
+			CKr:     dto.Ratchet.CKr,
+			DHs:     dto.Ratchet.DHs,
+			DHr:     dto.Ratchet.DHr,
+			Ns:      dto.Ratchet.Ns,
+			Nr:      dto.Ratchet.Nr,
+			PN:      dto.Ratchet.PN,
+			Skipped: dto.Ratchet.Skipped,
+		}
+	}
+	return nil
+}
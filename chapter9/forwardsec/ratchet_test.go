@@ -0,0 +1,153 @@
+package forwardsec
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"testing"
+)
+
+// TestSessionKeyMarshalUnmarshalRatchet exercises a full Alice/Bob
+// ratchet handshake and checks that a SessionKey can be persisted with
+// Marshal mid-conversation and resumed with Unmarshal: the restored
+// SessionKey must still decrypt a later message from the peer.
+func TestSessionKeyMarshalUnmarshalRatchet(t *testing.T) {
+	alice := NewEd25519IdentityKey()
+	bob := NewEd25519IdentityKey()
+	if alice == nil || bob == nil {
+		t.Fatal("failed to generate identity keys")
+	}
+
+	aliceVerifier, err := ImportPeerIdentity(alice.Public())
+	if err != nil {
+		t.Fatalf("import alice identity: %v", err)
+	}
+	bobVerifier, err := ImportPeerIdentity(bob.Public())
+	if err != nil {
+		t.Fatalf("import bob identity: %v", err)
+	}
+
+	aliceSession := alice.NewSessionKey()
+	bobSession := bob.NewSessionKey()
+	if aliceSession == nil || bobSession == nil {
+		t.Fatal("failed to generate session keys")
+	}
+
+	if err := aliceSession.PeerSessionKey(bobVerifier, bobSession.Public()); err != nil {
+		t.Fatalf("alice PeerSessionKey: %v", err)
+	}
+	if err := bobSession.PeerSessionKey(aliceVerifier, aliceSession.Public()); err != nil {
+		t.Fatalf("bob PeerSessionKey: %v", err)
+	}
+
+	if err := aliceSession.InitRatchetSender(); err != nil {
+		t.Fatalf("alice InitRatchetSender: %v", err)
+	}
+	if err := bobSession.InitRatchetReceiver(); err != nil {
+		t.Fatalf("bob InitRatchetReceiver: %v", err)
+	}
+
+	first := []byte("first ratchet message")
+	ct, err := aliceSession.EncryptRatchet(first)
+	if err != nil {
+		t.Fatalf("EncryptRatchet: %v", err)
+	}
+
+	pt, err := bobSession.DecryptRatchet(ct)
+	if err != nil {
+		t.Fatalf("DecryptRatchet: %v", err)
+	}
+	if !bytes.Equal(pt, first) {
+		t.Fatalf("first message round trip mismatch: got %q, want %q", pt, first)
+	}
+
+	data, err := bobSession.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	restored := new(SessionKey)
+	if err := restored.Unmarshal(data); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	second := []byte("second ratchet message, after restore")
+	ct, err = aliceSession.EncryptRatchet(second)
+	if err != nil {
+		t.Fatalf("EncryptRatchet (second): %v", err)
+	}
+
+	pt, err = restored.DecryptRatchet(ct)
+	if err != nil {
+		t.Fatalf("DecryptRatchet on restored session: %v", err)
+	}
+	if !bytes.Equal(pt, second) {
+		t.Fatalf("second message round trip mismatch: got %q, want %q", pt, second)
+	}
+}
+
+// TestDecryptRatchetRejectsForgedHeader checks that a forged ratchet
+// header with a made-up DH value and garbage ciphertext is rejected
+// without mutating the receiver's ratchet state, so a legitimate
+// message sent afterwards still decrypts normally.
+func TestDecryptRatchetRejectsForgedHeader(t *testing.T) {
+	alice := NewEd25519IdentityKey()
+	bob := NewEd25519IdentityKey()
+	if alice == nil || bob == nil {
+		t.Fatal("failed to generate identity keys")
+	}
+
+	aliceVerifier, err := ImportPeerIdentity(alice.Public())
+	if err != nil {
+		t.Fatalf("import alice identity: %v", err)
+	}
+	bobVerifier, err := ImportPeerIdentity(bob.Public())
+	if err != nil {
+		t.Fatalf("import bob identity: %v", err)
+	}
+
+	aliceSession := alice.NewSessionKey()
+	bobSession := bob.NewSessionKey()
+	if aliceSession == nil || bobSession == nil {
+		t.Fatal("failed to generate session keys")
+	}
+
+	if err := aliceSession.PeerSessionKey(bobVerifier, bobSession.Public()); err != nil {
+		t.Fatalf("alice PeerSessionKey: %v", err)
+	}
+	if err := bobSession.PeerSessionKey(aliceVerifier, aliceSession.Public()); err != nil {
+		t.Fatalf("bob PeerSessionKey: %v", err)
+	}
+
+	if err := aliceSession.InitRatchetSender(); err != nil {
+		t.Fatalf("alice InitRatchetSender: %v", err)
+	}
+	if err := bobSession.InitRatchetReceiver(); err != nil {
+		t.Fatalf("bob InitRatchetReceiver: %v", err)
+	}
+
+	forged, err := asn1.Marshal(ratchetMessage{
+		Header: ratchetHeader{DH: bytes.Repeat([]byte{0x41}, 32), PN: 0, N: 0},
+		CT:     bytes.Repeat([]byte{0x00}, 48),
+	})
+	if err != nil {
+		t.Fatalf("marshal forged message: %v", err)
+	}
+
+	if _, err := bobSession.DecryptRatchet(forged); err == nil {
+		t.Fatal("expected forged ratchet message to be rejected")
+	}
+
+	real := []byte("a real message after the forgery attempt")
+	ct, err := aliceSession.EncryptRatchet(real)
+	if err != nil {
+		t.Fatalf("EncryptRatchet: %v", err)
+	}
+
+	pt, err := bobSession.DecryptRatchet(ct)
+	if err != nil {
+		t.Fatalf("DecryptRatchet after rejected forgery: %v", err)
+	}
+	if !bytes.Equal(pt, real) {
+		t.Fatalf("post-forgery message mismatch: got %q, want %q", pt, real)
+	}
+}
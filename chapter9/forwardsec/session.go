@@ -0,0 +1,147 @@
+package forwardsec
+
+import (
+	"encoding/asn1"
+
+	"github.com/gokyle/dhkam"
+	"github.com/kisom/gocrypto/chapter9/authsym"
+)
+
+// A SessionKey should be generated for each new session with a single peer.
+type SessionKey struct {
+	key       *dhkam.PrivateKey
+	signedKey []byte
+	peer      *dhkam.PublicKey
+
+	// ratchet holds Double Ratchet state once InitRatchetSender or
+	// InitRatchetReceiver has been called; see ratchet.go. It is nil
+	// for sessions that only use the single-shot Encrypt/Decrypt.
+	ratchet *ratchetState
+}
+
+// AlgID values identify the session key exchange algorithm carried in
+// a signedDHKey, so that a peer can tell group-14 DH and X25519 blobs
+// apart.
+const (
+	algDH14   = "DH14"
+	algX25519 = "X25519"
+)
+
+type signedDHKey struct {
+	AlgID     string
+	Public    []byte
+	Signature []byte
+}
+
+// NewSessionKey builds a new DH group 14 session and returns it. Once
+// this is returned, the Public() value should be sent to the peer,
+// and once that Public() value is received, the peer should call
+// PeerSessionKey before attempting to use the session key for
+// encryption.
+func (id *IdentityKey) NewSessionKey() *SessionKey {
+	skey := new(SessionKey)
+
+	var err error
+	skey.key, err = dhkam.GenerateKey(PRNG)
+	if err != nil {
+		return nil
+	}
+
+	sdhkey := signedDHKey{AlgID: algDH14, Public: skey.key.Export()}
+	sdhkey.Signature, err = id.key.Sign(PRNG, sdhkey.Public)
+	if err != nil {
+		return nil
+	}
+
+	skey.signedKey, err = asn1.Marshal(sdhkey)
+	if err != nil {
+		return nil
+	}
+	return skey
+}
+
+// Public should be used to export the signed public key to the client
+func (skey *SessionKey) Public() []byte {
+	return skey.signedKey
+}
+
+// PeerSessionKey reads the session key passed and checks the
+// signature on it; if the signature is valid, it stores the peer's DH
+// public key for use by Encrypt/Decrypt.
+func (skey *SessionKey) PeerSessionKey(peer Verifier, session []byte) error {
+	var signedKey signedDHKey
+	_, err := asn1.Unmarshal(session, &signedKey)
+	if err != nil {
+		return err
+	}
+
+	if err = peer.Verify(signedKey.Public, signedKey.Signature); err != nil {
+		return err
+	}
+
+	pub, err := dhkam.ImportPublic(signedKey.Public)
+	if err != nil {
+		return err
+	}
+	skey.peer = pub
+	return nil
+}
+
+// Decrypt takes the incoming ciphertext and decrypts it.
+func (skey *SessionKey) Decrypt(in []byte) ([]byte, error) {
+	var ephem struct {
+		Pub []byte
+		CT  []byte
+	}
+
+	_, err := asn1.Unmarshal(in, &ephem)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := dhkam.ImportPublic(ephem.Pub)
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := skey.key.SharedKey(PRNG, pub, sharedKeyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	symkey := shared[:authsym.SymKeyLen]
+	mackey := shared[authsym.SymKeyLen:]
+	out, err := authsym.Decrypt(symkey, mackey, ephem.CT)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Encrypt takes a message and encrypts it to the session's peer.
+func (skey *SessionKey) Encrypt(message []byte) ([]byte, error) {
+	dhEphem, err := dhkam.GenerateKey(PRNG)
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := dhEphem.SharedKey(PRNG, skey.peer, sharedKeyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	var ephem struct {
+		Pub []byte
+		CT  []byte
+	}
+
+	symkey := shared[:authsym.SymKeyLen]
+	mackey := shared[authsym.SymKeyLen:]
+	ephem.CT, err = authsym.Encrypt(symkey, mackey, message)
+	if err != nil {
+		return nil, err
+	}
+
+	ephem.Pub = dhEphem.Export()
+	return asn1.Marshal(ephem)
+}
@@ -0,0 +1,155 @@
+package forwardsec
+
+import (
+	"crypto/sha256"
+	"encoding/asn1"
+	"fmt"
+	"io"
+
+	"github.com/kisom/gocrypto/chapter9/authsym"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+var x25519HKDFInfo = []byte("forwardsec x25519 session")
+
+// An X25519SessionKey should be generated for each new session with a
+// single peer. It behaves like SessionKey but negotiates over
+// Curve25519 instead of DH group 14.
+type X25519SessionKey struct {
+	priv      [32]byte
+	pub       [32]byte
+	signedKey []byte
+	peer      [32]byte
+}
+
+// NewX25519SessionKey generates a fresh Curve25519 keypair, signs the
+// public value with the identity key, and returns the session. Once
+// returned, the Public() value should be sent to the peer, and once
+// the peer's Public() value is received, PeerSessionKey should be
+// called before Encrypt/Decrypt are used.
+func (id *IdentityKey) NewX25519SessionKey() *X25519SessionKey {
+	skey := new(X25519SessionKey)
+
+	if _, err := io.ReadFull(PRNG, skey.priv[:]); err != nil {
+		return nil
+	}
+
+	pub, err := curve25519.X25519(skey.priv[:], curve25519.Basepoint)
+	if err != nil {
+		return nil
+	}
+	copy(skey.pub[:], pub)
+
+	sdhkey := signedDHKey{AlgID: algX25519, Public: skey.pub[:]}
+	sdhkey.Signature, err = id.key.Sign(PRNG, sdhkey.Public)
+	if err != nil {
+		return nil
+	}
+
+	skey.signedKey, err = asn1.Marshal(sdhkey)
+	if err != nil {
+		return nil
+	}
+	return skey
+}
+
+// Public should be used to export the signed public key to the peer.
+func (skey *X25519SessionKey) Public() []byte {
+	return skey.signedKey
+}
+
+// PeerSessionKey reads the session key passed and checks the
+// signature on it; if the signature is valid, it stores the peer's
+// X25519 public value for use by Encrypt/Decrypt.
+func (skey *X25519SessionKey) PeerSessionKey(peer Verifier, session []byte) error {
+	var signedKey signedDHKey
+	_, err := asn1.Unmarshal(session, &signedKey)
+	if err != nil {
+		return err
+	}
+
+	if signedKey.AlgID != algX25519 {
+		return fmt.Errorf("forwardsec: expected X25519 session key, got %q", signedKey.AlgID)
+	}
+	if len(signedKey.Public) != 32 {
+		return fmt.Errorf("forwardsec: invalid X25519 public value")
+	}
+
+	if err = peer.Verify(signedKey.Public, signedKey.Signature); err != nil {
+		return err
+	}
+
+	copy(skey.peer[:], signedKey.Public)
+	return nil
+}
+
+// x25519SharedKeys runs the raw Curve25519 shared secret through HKDF
+// to derive the symmetric and MAC keys authsym expects.
+func x25519SharedKeys(priv, peer [32]byte) (symkey, mackey []byte, err error) {
+	dh, err := curve25519.X25519(priv[:], peer[:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	shared := make([]byte, sharedKeyLen)
+	kdf := hkdf.New(sha256.New, dh, nil, x25519HKDFInfo)
+	if _, err = io.ReadFull(kdf, shared); err != nil {
+		return nil, nil, err
+	}
+	return shared[:authsym.SymKeyLen], shared[authsym.SymKeyLen:], nil
+}
+
+// Decrypt takes the incoming ciphertext and decrypts it.
+func (skey *X25519SessionKey) Decrypt(in []byte) ([]byte, error) {
+	var ephem struct {
+		Pub []byte
+		CT  []byte
+	}
+
+	_, err := asn1.Unmarshal(in, &ephem)
+	if err != nil {
+		return nil, err
+	}
+	if len(ephem.Pub) != 32 {
+		return nil, fmt.Errorf("forwardsec: invalid X25519 public value")
+	}
+
+	var peerEphem [32]byte
+	copy(peerEphem[:], ephem.Pub)
+
+	symkey, mackey, err := x25519SharedKeys(skey.priv, peerEphem)
+	if err != nil {
+		return nil, err
+	}
+	return authsym.Decrypt(symkey, mackey, ephem.CT)
+}
+
+// Encrypt takes a message and encrypts it to the session's peer.
+func (skey *X25519SessionKey) Encrypt(message []byte) ([]byte, error) {
+	var ephemPriv [32]byte
+	if _, err := io.ReadFull(PRNG, ephemPriv[:]); err != nil {
+		return nil, err
+	}
+
+	ephemPub, err := curve25519.X25519(ephemPriv[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+
+	symkey, mackey, err := x25519SharedKeys(ephemPriv, skey.peer)
+	if err != nil {
+		return nil, err
+	}
+
+	var ephem struct {
+		Pub []byte
+		CT  []byte
+	}
+	ephem.Pub = ephemPub
+	ephem.CT, err = authsym.Encrypt(symkey, mackey, message)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(ephem)
+}